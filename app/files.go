@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpTimeFormat is the format used for the "Last-Modified" response header
+// and expected in the "If-Modified-Since" request header, per RFC 7231 §7.1.1.1.
+const httpTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// fileETag computes a weak ETag from a file's size and modification time, so
+// it's cheap to recompute on every request without hashing file contents.
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// notModified reports whether req's conditional headers indicate the client
+// already holds the current representation described by etag and modTime.
+// If-None-Match takes precedence over If-Modified-Since, per RFC 7232 §6.
+func notModified(req *httpRequest, etag string, modTime time.Time) bool {
+	if req.IfNoneMatch != "" {
+		return etagMatches(req.IfNoneMatch, etag)
+	}
+	if req.IfModifiedSince != "" {
+		if since, err := time.Parse(httpTimeFormat, req.IfModifiedSince); err == nil {
+			return !modTime.UTC().Truncate(time.Second).After(since)
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether etag appears in the comma-separated list of
+// ETags from an If-None-Match header, or the list is the wildcard "*".
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// byteRange is an inclusive, already-bounds-checked byte range within a file
+// of a known size.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 { return r.end - r.start + 1 }
+
+// parseRanges parses a "Range: bytes=..." header value into one or more
+// byteRanges against a file of the given size, supporting suffix ("-500"),
+// open-ended ("500-") and closed ("500-999") ranges. It returns an error if
+// the header names an unsupported unit, is malformed, or any range it asks
+// for is not wholly within [0, size); an end past the last byte is not
+// clamped.
+func parseRanges(header string, size int64) ([]byteRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, fmt.Errorf("unsupported range unit in %q", header)
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("no ranges satisfiable for an empty file")
+	}
+
+	var ranges []byteRange
+	for _, part := range strings.Split(header[len(prefix):], ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		start, end, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("malformed range %q", part)
+		}
+
+		var r byteRange
+		switch {
+		case start == "":
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("malformed suffix range %q", part)
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, end: size - 1}
+		case end == "":
+			s, err := strconv.ParseInt(start, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed range %q", part)
+			}
+			r = byteRange{start: s, end: size - 1}
+		default:
+			s, errS := strconv.ParseInt(start, 10, 64)
+			e, errE := strconv.ParseInt(end, 10, 64)
+			if errS != nil || errE != nil || s > e {
+				return nil, fmt.Errorf("malformed range %q", part)
+			}
+			r = byteRange{start: s, end: e}
+		}
+
+		if r.start < 0 || r.start >= size || r.end >= size {
+			return nil, fmt.Errorf("range %q not satisfiable for size %d", part, size)
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ranges found in %q", header)
+	}
+	return ranges, nil
+}
+
+// serveSingleRange writes a 206 Partial Content response for a single byte
+// range, seeking file to r.start and streaming exactly r.length() bytes.
+func serveSingleRange(w ResponseWriter, file *os.File, r byteRange, size int64) {
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(r.length(), 10))
+	w.WriteHeader(206)
+
+	if _, err := file.Seek(r.start, io.SeekStart); err != nil {
+		fmt.Println("Error seeking file:", err.Error())
+		return
+	}
+	if _, err := io.CopyN(w, file, r.length()); err != nil {
+		fmt.Println("Error streaming range:", err.Error())
+	}
+}
+
+// serveMultiRange writes a 206 Partial Content response with a
+// multipart/byteranges body, one part per range in ranges.
+func serveMultiRange(w ResponseWriter, file *os.File, ranges []byteRange, size int64) {
+	boundary := generateBoundary()
+	w.Header().Set("Content-Type", fmt.Sprintf("multipart/byteranges; boundary=%s", boundary))
+	w.WriteHeader(206)
+
+	for _, r := range ranges {
+		fmt.Fprintf(w, "--%s\r\n", boundary)
+		fmt.Fprintf(w, "Content-Type: application/octet-stream\r\n")
+		fmt.Fprintf(w, "Content-Range: bytes %d-%d/%d\r\n\r\n", r.start, r.end, size)
+
+		if _, err := file.Seek(r.start, io.SeekStart); err != nil {
+			fmt.Println("Error seeking file:", err.Error())
+			return
+		}
+		if _, err := io.CopyN(w, file, r.length()); err != nil {
+			fmt.Println("Error streaming range:", err.Error())
+			return
+		}
+		fmt.Fprintf(w, "\r\n")
+	}
+	fmt.Fprintf(w, "--%s--\r\n", boundary)
+}
+
+// generateBoundary returns a random multipart boundary string.
+func generateBoundary() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "httpserverboundary"
+	}
+	return fmt.Sprintf("%x", buf)
+}
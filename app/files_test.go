@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRanges(t *testing.T) {
+	const size = 20 // valid byte indices 0-19
+
+	tests := []struct {
+		name    string
+		header  string
+		want    []byteRange
+		wantErr bool
+	}{
+		{
+			name:   "closed range",
+			header: "bytes=0-4",
+			want:   []byteRange{{start: 0, end: 4}},
+		},
+		{
+			name:   "open-ended range",
+			header: "bytes=15-",
+			want:   []byteRange{{start: 15, end: 19}},
+		},
+		{
+			name:   "suffix range",
+			header: "bytes=-5",
+			want:   []byteRange{{start: 15, end: 19}},
+		},
+		{
+			name:   "suffix range larger than the file",
+			header: "bytes=-9999",
+			want:   []byteRange{{start: 0, end: 19}},
+		},
+		{
+			name:   "range ending exactly at the last byte",
+			header: "bytes=19-19",
+			want:   []byteRange{{start: 19, end: 19}},
+		},
+		{
+			name:   "multiple ranges",
+			header: "bytes=0-3,5-8",
+			want:   []byteRange{{start: 0, end: 3}, {start: 5, end: 8}},
+		},
+		{
+			name:   "multiple ranges with whitespace",
+			header: "bytes=0-3, 5-8",
+			want:   []byteRange{{start: 0, end: 3}, {start: 5, end: 8}},
+		},
+		{
+			name:    "unsupported unit",
+			header:  "items=0-4",
+			wantErr: true,
+		},
+		{
+			name:    "start beyond end of file",
+			header:  "bytes=20-25",
+			wantErr: true,
+		},
+		{
+			name:    "end beyond end of file",
+			header:  "bytes=10-25",
+			wantErr: true,
+		},
+		{
+			name:    "start after end",
+			header:  "bytes=10-5",
+			wantErr: true,
+		},
+		{
+			name:    "malformed suffix length",
+			header:  "bytes=-0",
+			wantErr: true,
+		},
+		{
+			name:    "malformed, no dash",
+			header:  "bytes=5",
+			wantErr: true,
+		},
+		{
+			name:    "non-numeric bound",
+			header:  "bytes=a-5",
+			wantErr: true,
+		},
+		{
+			name:    "empty range list",
+			header:  "bytes=",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRanges(tt.header, size)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseRanges(%q, %d) = %v, want an error", tt.header, size, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRanges(%q, %d) returned unexpected error: %v", tt.header, size, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRanges(%q, %d) = %+v, want %+v", tt.header, size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRangesEmptyFile(t *testing.T) {
+	if _, err := parseRanges("bytes=0-0", 0); err == nil {
+		t.Error("parseRanges against a zero-size file should return an error, got nil")
+	}
+}
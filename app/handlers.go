@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// newMux builds the ServeMux that routes every request this server knows
+// how to handle.
+func newMux() *ServeMux {
+	mux := NewServeMux()
+	mux.Handle("/", HandlerFunc(pingHandler))
+	mux.Handle("GET /echo/{msg}", HandlerFunc(echoHandler))
+	mux.Handle("GET /user-agent", HandlerFunc(userAgentHandler))
+	mux.Handle("GET /files/{name}", HandlerFunc(getFileHandler))
+	mux.Handle("POST /files/{name}", HandlerFunc(postFileHandler))
+	return mux
+}
+
+// pingHandler is the universal "is the server up" ping back; no action
+// needed beyond the 200 ResponseWriter defaults to.
+func pingHandler(w ResponseWriter, req *httpRequest) {
+	w.WriteHeader(200)
+}
+
+func echoHandler(w ResponseWriter, req *httpRequest) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(req.PathValue("msg")))
+}
+
+func userAgentHandler(w ResponseWriter, req *httpRequest) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(req.UserAgent))
+}
+
+// getFileHandler streams the requested file back instead of buffering it
+// whole into memory, so large files under -directory don't blow up process
+// memory. It honors conditional GETs (If-None-Match / If-Modified-Since)
+// and Range requests against a weak ETag and Last-Modified time derived
+// from os.Stat.
+func getFileHandler(w ResponseWriter, req *httpRequest) {
+	path, status := resolveFilePath(req.PathValue("name"))
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		fmt.Println("Error getting file:", err.Error())
+		w.WriteHeader(500)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		fmt.Println("Error stating file:", err.Error())
+		w.WriteHeader(500)
+		return
+	}
+
+	etag := fileETag(info)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(httpTimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	if notModified(req, etag, info.ModTime()) {
+		w.WriteHeader(304)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	if req.Range == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.WriteHeader(200)
+		if _, err := io.Copy(w, file); err != nil {
+			fmt.Println("Error streaming file:", err.Error())
+		}
+		return
+	}
+
+	ranges, err := parseRanges(req.Range, info.Size())
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size()))
+		w.WriteHeader(416)
+		return
+	}
+
+	if len(ranges) == 1 {
+		serveSingleRange(w, file, ranges[0], info.Size())
+		return
+	}
+	serveMultiRange(w, file, ranges, info.Size())
+}
+
+func postFileHandler(w ResponseWriter, req *httpRequest) {
+	path, status := resolveFilePath(req.PathValue("name"))
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	if int64(len(req.Body)) > *maxUploadSize {
+		w.WriteHeader(413)
+		return
+	}
+
+	// O_EXCL makes the existence check and the write atomic, so two
+	// concurrent uploads to the same name can't both pass a separate stat
+	// check and race each other to clobber the file.
+	flags := os.O_WRONLY | os.O_CREATE
+	if *allowOverwrite {
+		flags |= os.O_TRUNC
+	} else {
+		flags |= os.O_EXCL
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		w.WriteHeader(409)
+		return
+	} else if err != nil {
+		fmt.Printf("Error opening file %s: %s", path, err.Error())
+		w.WriteHeader(500)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(req.Body); err != nil {
+		fmt.Printf("Error writing file %s: %s", path, err.Error())
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(201)
+}
@@ -0,0 +1,130 @@
+package main
+
+import "strings"
+
+// Handler responds to a single HTTP request routed to it by a ServeMux.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, req *httpRequest)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(w ResponseWriter, req *httpRequest)
+
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, req *httpRequest) {
+	f(w, req)
+}
+
+// muxEntry is a single registered route: an optional method ("" matches any
+// method) paired with a pattern whose segments may be literal text or a
+// "{name}" path parameter.
+type muxEntry struct {
+	method   string
+	segments []string
+	handler  Handler
+}
+
+// ServeMux routes requests to registered Handlers by method and path,
+// extracting "{name}" path segments for httpRequest.PathValue.
+type ServeMux struct {
+	entries []muxEntry
+}
+
+// NewServeMux creates an empty ServeMux.
+func NewServeMux() *ServeMux {
+	return &ServeMux{}
+}
+
+// Handle registers handler for pattern. pattern is either "METHOD /path",
+// e.g. "GET /echo/{msg}", to match only that method, or just "/path" to
+// match every method.
+func (mux *ServeMux) Handle(pattern string, handler Handler) {
+	method, path := splitPattern(pattern)
+	mux.entries = append(mux.entries, muxEntry{
+		method:   method,
+		segments: pathSegments(path),
+		handler:  handler,
+	})
+}
+
+// HandleFunc is the HandlerFunc equivalent of Handle.
+func (mux *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *httpRequest)) {
+	mux.Handle(pattern, HandlerFunc(handler))
+}
+
+// ServeHTTP implements Handler so a ServeMux can itself be mounted as a
+// sub-handler. It resolves req against the registered routes, writing 404
+// when no pattern matches the path and 405 when the path matches but not
+// for req's method.
+func (mux *ServeMux) ServeHTTP(w ResponseWriter, req *httpRequest) {
+	handler, params, status := mux.handler(req)
+	if handler == nil {
+		w.WriteHeader(status)
+		return
+	}
+	req.pathParams = params
+	handler.ServeHTTP(w, req)
+}
+
+func (mux *ServeMux) handler(req *httpRequest) (handler Handler, params map[string]string, status int) {
+	segments := pathSegments(requestPath(req.Target))
+
+	pathMatched := false
+	for _, entry := range mux.entries {
+		matchedParams, ok := matchSegments(entry.segments, segments)
+		if !ok {
+			continue
+		}
+		pathMatched = true
+
+		if entry.method != "" && entry.method != req.Method {
+			continue
+		}
+		return entry.handler, matchedParams, 200
+	}
+
+	if pathMatched {
+		return nil, nil, 405
+	}
+	return nil, nil, 404
+}
+
+// splitPattern splits a "METHOD /path" registration pattern into its method
+// (empty if the pattern has none, matching any method) and its path.
+func splitPattern(pattern string) (method, path string) {
+	if m, p, ok := strings.Cut(pattern, " "); ok {
+		return m, p
+	}
+	return "", pattern
+}
+
+// requestPath strips any query string off of a request target.
+func requestPath(target string) string {
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		return target[:i]
+	}
+	return target
+}
+
+func pathSegments(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// matchSegments compares a registered pattern's segments against a request
+// path's segments, collecting "{name}" segments into a path parameter map.
+func matchSegments(pattern, actual []string) (map[string]string, bool) {
+	if len(pattern) != len(actual) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, seg := range pattern {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params[seg[1:len(seg)-1]] = actual[i]
+			continue
+		}
+		if seg != actual[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// InvalidTargetError reports a malformed request target: undecodable
+// percent-encoding, a ".." segment, or an embedded NUL byte.
+type InvalidTargetError struct {
+	Target string
+	Reason string
+}
+
+func (e *InvalidTargetError) Error() string {
+	return fmt.Sprintf("invalid path %q: %s", e.Target, e.Reason)
+}
+
+// PathTraversalError reports that target still resolves outside of the
+// served root once symlinks are taken into account.
+type PathTraversalError struct {
+	Target string
+}
+
+func (e *PathTraversalError) Error() string {
+	return fmt.Sprintf("path %q escapes the served root directory", e.Target)
+}
+
+// safeJoin URL-decodes target and resolves it against root, refusing to
+// produce a path outside of root via ".." segments or symlinks. Callers
+// should map *InvalidTargetError to 400 and *PathTraversalError to 403.
+func safeJoin(root, target string) (string, error) {
+	decoded, err := url.PathUnescape(target)
+	if err != nil {
+		return "", &InvalidTargetError{Target: target, Reason: "invalid percent-encoding"}
+	}
+	if strings.ContainsRune(decoded, 0) {
+		return "", &InvalidTargetError{Target: target, Reason: "contains a NUL byte"}
+	}
+	for _, segment := range strings.Split(decoded, "/") {
+		if segment == ".." {
+			return "", &InvalidTargetError{Target: target, Reason: `contains a ".." segment`}
+		}
+	}
+
+	cleanRoot := filepath.Clean(root)
+	joined := filepath.Join(cleanRoot, decoded)
+
+	resolved, err := resolveExistingPrefix(joined)
+	if err != nil {
+		return "", err
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(cleanRoot)
+	if err != nil {
+		resolvedRoot = cleanRoot
+	}
+
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &PathTraversalError{Target: target}
+	}
+
+	return resolved, nil
+}
+
+// resolveExistingPrefix resolves symlinks along the longest prefix of path
+// that actually exists, then re-joins the remaining, nonexistent suffix
+// unresolved. Plain filepath.EvalSymlinks(path) fails with fs.ErrNotExist
+// whenever the final component doesn't exist yet (the common case for a new
+// upload), which would otherwise skip symlink resolution entirely even when
+// an earlier component -- e.g. the directory the upload lands in -- is a
+// symlink.
+func resolveExistingPrefix(path string) (string, error) {
+	suffix := ""
+	current := path
+
+	for {
+		resolved, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			return filepath.Join(resolved, suffix), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			// Reached the root of the filesystem without finding anything
+			// that exists; nothing left to resolve.
+			return filepath.Join(current, suffix), nil
+		}
+		suffix = filepath.Join(filepath.Base(current), suffix)
+		current = parent
+	}
+}
+
+// resolveFilePath resolves name against *serverDirectory via safeJoin,
+// returning the status a handler should send back instead if the target is
+// invalid or attempts to escape the served root. status is 0 when path is
+// safe to use.
+func resolveFilePath(name string) (path string, status int) {
+	path, err := safeJoin(*serverDirectory, name)
+	var invalidErr *InvalidTargetError
+	var traversalErr *PathTraversalError
+	switch {
+	case errors.As(err, &invalidErr):
+		return "", 400
+	case errors.As(err, &traversalErr):
+		return "", 403
+	case err != nil:
+		fmt.Println("Error resolving file path:", err.Error())
+		return "", 500
+	}
+	return path, 0
+}
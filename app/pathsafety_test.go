@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(root, "existing.txt"), []byte("hi"), 0644); err != nil {
+		t.Fatalf("failed to set up fixture file: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatalf("failed to set up fixture dir: %v", err)
+	}
+
+	outsideDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outsideDir, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("failed to set up fixture file: %v", err)
+	}
+	if err := os.Symlink(outsideDir, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symlinks unsupported in this environment: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		want        string // expected absolute path under root; ignored if wantErrType is set
+		wantErrType error
+	}{
+		{
+			name:   "plain file",
+			target: "existing.txt",
+			want:   filepath.Join(root, "existing.txt"),
+		},
+		{
+			name:   "nested new file",
+			target: "sub/new.txt",
+			want:   filepath.Join(root, "sub/new.txt"),
+		},
+		{
+			name:   "percent-encoded space",
+			target: "sub/a%20b.txt",
+			want:   filepath.Join(root, "sub/a b.txt"),
+		},
+		{
+			name:        "dotdot segment",
+			target:      "../escape/secret.txt",
+			wantErrType: &InvalidTargetError{},
+		},
+		{
+			name:        "encoded dotdot segment",
+			target:      "..%2f..%2fetc%2fpasswd",
+			wantErrType: &InvalidTargetError{},
+		},
+		{
+			name:        "embedded NUL byte",
+			target:      "existing.txt%00.png",
+			wantErrType: &InvalidTargetError{},
+		},
+		{
+			name:        "invalid percent-encoding",
+			target:      "existing.txt%",
+			wantErrType: &InvalidTargetError{},
+		},
+		{
+			name:        "symlink escaping root",
+			target:      "escape/secret.txt",
+			wantErrType: &PathTraversalError{},
+		},
+		{
+			name:        "new file under a symlinked directory escaping root",
+			target:      "escape/newfile.txt",
+			wantErrType: &PathTraversalError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := safeJoin(root, tt.target)
+
+			if tt.wantErrType != nil {
+				if err == nil {
+					t.Fatalf("safeJoin(%q, %q) = %q, nil; want a %T", root, tt.target, got, tt.wantErrType)
+				}
+				switch tt.wantErrType.(type) {
+				case *InvalidTargetError:
+					var target *InvalidTargetError
+					if !errors.As(err, &target) {
+						t.Errorf("safeJoin(%q, %q) error = %v, want *InvalidTargetError", root, tt.target, err)
+					}
+				case *PathTraversalError:
+					var target *PathTraversalError
+					if !errors.As(err, &target) {
+						t.Errorf("safeJoin(%q, %q) error = %v, want *PathTraversalError", root, tt.target, err)
+					}
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("safeJoin(%q, %q) returned unexpected error: %v", root, tt.target, err)
+			}
+			if got != tt.want {
+				t.Errorf("safeJoin(%q, %q) = %q, want %q", root, tt.target, got, tt.want)
+			}
+		})
+	}
+}
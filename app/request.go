@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxMessageSize uint32 = 1 << 30 // 1GB (2^30 bytes); hard ceiling regardless of -max-upload-size
+
+// requestBodyCap returns the maximum number of bytes a request body may
+// declare (via Content-Length) or stream (via chunked transfer) before
+// parseHTTPRequest rejects it outright, rather than allocating or reading
+// past that point: the smaller of the hard maxMessageSize ceiling and the
+// operator-configured *maxUploadSize.
+func requestBodyCap() int64 {
+	limit := int64(maxMessageSize)
+	if *maxUploadSize < limit {
+		limit = *maxUploadSize
+	}
+	return limit
+}
+
+type httpRequest struct {
+	Line             string
+	Body             []byte
+	Method           string
+	Target           string
+	HTTPVersion      string
+	Host             string // Server host and port
+	UserAgent        string // Client user agent
+	Accept           string // Media types the client accepts
+	ContentType      string
+	ContentLength    int
+	AcceptEncoding   string
+	Connection       string            // Raw "Connection" header value, e.g. "close" or "keep-alive"
+	TransferEncoding string            // Raw "Transfer-Encoding" header value, e.g. "chunked"
+	Range            string            // Raw "Range" header value, e.g. "bytes=0-499"
+	IfNoneMatch      string            // Raw "If-None-Match" header value
+	IfModifiedSince  string            // Raw "If-Modified-Since" header value
+	pathParams       map[string]string // Path parameters extracted by the ServeMux route that matched this request
+}
+
+// PathValue returns the value matched for the named "{name}" path
+// parameter in the ServeMux pattern that routed this request, or "" if
+// there is no such parameter.
+func (req *httpRequest) PathValue(name string) string {
+	return req.pathParams[name]
+}
+
+type TargetParseError struct {
+	Details string
+}
+
+func (e *TargetParseError) Error() string {
+	return fmt.Sprintf("error parsing request target: %s", e.Details)
+}
+
+func parseHTTPRequest(reader *bufio.Reader) (req httpRequest, err error) {
+	// Line
+	line, err := readLine(reader)
+	if err != nil {
+		return
+	}
+	req.Line = line
+	parts := strings.SplitN(line, " ", 3)
+
+	if len(parts) < 3 {
+		fmt.Println("Error parsing line. Missing part")
+		return req, fmt.Errorf("missing part when parsing line")
+	}
+
+	req.Method = parts[0]
+	req.Target = parts[1]
+	req.HTTPVersion = parts[2]
+
+	// Headers
+	for {
+		line, err = readLine(reader)
+		if err != nil {
+			fmt.Println("Error parsing header part:", err.Error())
+			return
+		}
+		if line == "" {
+			break // Finished parsing header
+		}
+
+		parts := strings.SplitN(line, ": ", 2)
+
+		switch strings.ToLower(parts[0]) {
+		case "host":
+			req.Host = parts[1]
+		case "user-agent":
+			req.UserAgent = parts[1]
+		case "accept":
+			req.Accept = parts[1]
+		case "content-type":
+			req.ContentType = parts[1]
+		case "content-length":
+			{
+				num, err := strconv.Atoi(parts[1])
+				if err != nil {
+					fmt.Println("Error parsing content-length", parts[1])
+					req.ContentLength = -1
+				} else {
+					req.ContentLength = num
+				}
+			}
+		case "accept-encoding":
+			req.AcceptEncoding = parts[1]
+		case "connection":
+			req.Connection = parts[1]
+		case "transfer-encoding":
+			req.TransferEncoding = parts[1]
+		case "range":
+			req.Range = parts[1]
+		case "if-none-match":
+			req.IfNoneMatch = parts[1]
+		case "if-modified-since":
+			req.IfModifiedSince = parts[1]
+
+		default:
+			fmt.Println("Error parsing header part. Unknown label:", parts[0])
+		}
+	}
+
+	switch {
+	case strings.EqualFold(strings.TrimSpace(req.TransferEncoding), "chunked"):
+		// Transfer-Encoding takes precedence over any Content-Length, per RFC 7230 3.3.3.
+		req.Body, err = readChunkedBody(reader)
+		if err != nil {
+			fmt.Println("Error reading chunked body:", err.Error())
+			return req, err
+		}
+	case req.ContentLength > 0:
+		if limit := requestBodyCap(); int64(req.ContentLength) > limit {
+			return req, fmt.Errorf("content-length %d exceeds maximum allowed body size of %d bytes", req.ContentLength, limit)
+		}
+		buff := make([]byte, req.ContentLength)
+		_, err = io.ReadFull(reader, buff)
+		if err != nil {
+			fmt.Println("Error filling buffer from body")
+			return
+		}
+		req.Body = buff
+	}
+
+	return req, nil
+}
+
+// readChunkedBody reads a request body encoded with Transfer-Encoding:
+// chunked off of reader: a sequence of "<hex size>\r\n<data>\r\n" chunks
+// terminated by a zero-size chunk, followed by optional trailer headers and
+// a final blank line.
+func readChunkedBody(reader *bufio.Reader) (body []byte, err error) {
+	for {
+		sizeLine, err := readLine(reader)
+		if err != nil {
+			return nil, fmt.Errorf("error reading chunk size: %w", err)
+		}
+
+		// Chunk extensions (";key=value") aren't supported, just ignored.
+		sizeLine = strings.TrimSpace(strings.SplitN(sizeLine, ";", 2)[0])
+		size, err := strconv.ParseInt(sizeLine, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size %q: %w", sizeLine, err)
+		}
+
+		if size == 0 {
+			// Trailing headers, if any, then the terminating blank line.
+			for {
+				line, err := readLine(reader)
+				if err != nil {
+					return nil, fmt.Errorf("error reading trailer: %w", err)
+				}
+				if line == "" {
+					break
+				}
+			}
+			return body, nil
+		}
+
+		if limit := requestBodyCap(); int64(len(body))+size > limit {
+			return nil, fmt.Errorf("chunked body exceeded limit of %d bytes", limit)
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, fmt.Errorf("error reading chunk data: %w", err)
+		}
+		body = append(body, chunk...)
+
+		if _, err := readLine(reader); err != nil {
+			return nil, fmt.Errorf("error reading chunk terminator: %w", err)
+		}
+	}
+}
+
+func readLine(reader *bufio.Reader) (lineStr string, err error) {
+	var line []byte
+	for {
+		part, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, part...)
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
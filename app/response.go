@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Header holds response headers to be written by ResponseWriter.WriteHeader.
+type Header map[string]string
+
+func (h Header) Set(key, value string) { h[key] = value }
+func (h Header) Get(key string) string { return h[key] }
+func (h Header) Del(key string)        { delete(h, key) }
+
+// ResponseWriter lets a Handler build its response incrementally instead of
+// returning a fully-built byte slice: set headers, call WriteHeader once a
+// status code is decided, then Write the body, which may be streamed in
+// chunks rather than built up in memory first.
+type ResponseWriter interface {
+	Header() Header
+	WriteHeader(statusCode int)
+	Write(p []byte) (int, error)
+}
+
+// httpResponseWriter is the ResponseWriter for a single request, writing
+// onto the connection's persistent *bufio.Writer. Content-Length is used
+// when a handler sets one before the header is written (by WriteHeader or
+// its first Write); otherwise the body is
+// framed as Transfer-Encoding: chunked so handlers can stream a response of
+// unknown length (e.g. a file) without buffering it first.
+type httpResponseWriter struct {
+	conn             *bufio.Writer
+	header           Header
+	statusCode       int
+	connectionHeader string
+	wroteHeader      bool
+	chunked          bool
+}
+
+func newHTTPResponseWriter(conn *bufio.Writer, connectionHeader string) *httpResponseWriter {
+	return &httpResponseWriter{
+		conn:             conn,
+		header:           Header{},
+		statusCode:       200,
+		connectionHeader: connectionHeader,
+	}
+}
+
+func (w *httpResponseWriter) Header() Header { return w.header }
+
+func (w *httpResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader, w.statusCode = true, statusCode
+
+	if w.connectionHeader != "" {
+		w.header.Set("Connection", w.connectionHeader)
+	}
+	if !statusHasNoBody(statusCode) && w.header.Get("Content-Length") == "" {
+		w.chunked = true
+		w.header.Set("Transfer-Encoding", "chunked")
+	}
+
+	w.conn.WriteString(getStatusLine(statusCode))
+	for key, value := range w.header {
+		fmt.Fprintf(w.conn, "%s: %s\r\n", key, value)
+	}
+	w.conn.WriteString("\r\n")
+}
+
+func (w *httpResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(w.statusCode)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.chunked {
+		fmt.Fprintf(w.conn, "%x\r\n", len(p))
+		n, err := w.conn.Write(p)
+		w.conn.WriteString("\r\n")
+		return n, err
+	}
+	return w.conn.Write(p)
+}
+
+// finish sends headers for a handler that never wrote a body, terminates
+// chunked framing if it was used, and flushes the connection's buffer.
+func (w *httpResponseWriter) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(w.statusCode)
+	}
+	if w.chunked {
+		w.conn.WriteString("0\r\n\r\n")
+	}
+	return w.conn.Flush()
+}
+
+// finisher is implemented by ResponseWriters that need a chance to flush
+// trailing framing once a Handler has returned.
+type finisher interface {
+	finish() error
+}
+
+// statusHasNoBody reports whether a response with this status code must
+// never carry a body, per RFC 7230 §3.3.1/§3.3.2 (1xx, 204) and RFC 7232
+// §4.1 (304). Framing headers like Transfer-Encoding and Content-Encoding
+// are meaningless, and actively wrong, on such a response.
+func statusHasNoBody(statusCode int) bool {
+	switch {
+	case statusCode >= 100 && statusCode < 200:
+		return true
+	case statusCode == 204 || statusCode == 304:
+		return true
+	default:
+		return false
+	}
+}
+
+// compressionResponseWriter wraps a ResponseWriter, transparently applying
+// whichever Content-Encoding selectEncoding picks for the wrapped request's
+// Accept-Encoding header to everything written through it.
+//
+// Negotiation (and forwarding WriteHeader to the wrapped ResponseWriter) is
+// deferred until the first real Write, or to finish() if Write is never
+// called, so a response that turns out to have no body (or a status that
+// forbids one, like 304) never ends up framed as a compressed body.
+type compressionResponseWriter struct {
+	ResponseWriter
+	req          *httpRequest
+	statusCode   int
+	headerCalled bool
+	negotiated   bool
+	encoder      io.WriteCloser
+}
+
+func (w *compressionResponseWriter) WriteHeader(statusCode int) {
+	if w.headerCalled {
+		return
+	}
+	w.headerCalled, w.statusCode = true, statusCode
+}
+
+func (w *compressionResponseWriter) Write(p []byte) (int, error) {
+	if !w.headerCalled {
+		w.WriteHeader(200)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if !w.negotiated {
+		w.negotiated = true
+		if !statusHasNoBody(w.statusCode) {
+			contentType := w.Header().Get("Content-Type")
+			coding := selectEncoding(w.req.AcceptEncoding)
+			if coding != "" && !incompressibleContentTypes[contentType] && len(p) >= *compressionMinSize {
+				encoder, err := newEncoder(coding, w.ResponseWriter)
+				if err != nil {
+					fmt.Println("Error setting up response compression:", err.Error())
+				} else {
+					w.encoder = encoder
+					w.Header().Set("Content-Encoding", coding)
+					w.Header().Set("Vary", "Accept-Encoding")
+					w.Header().Del("Content-Length") // Length changes once compressed; stream it instead
+				}
+			}
+		}
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// finish closes the active encoder, flushing any compressed bytes it's
+// still holding onto the wrapped ResponseWriter, then gives the wrapped
+// ResponseWriter the same chance to finish if it needs one. If Write was
+// never called, this is what finally sends the (uncompressed, bodyless)
+// header.
+func (w *compressionResponseWriter) finish() error {
+	if !w.negotiated {
+		if !w.headerCalled {
+			w.WriteHeader(200)
+		}
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	} else if w.encoder != nil {
+		if err := w.encoder.Close(); err != nil {
+			return fmt.Errorf("error closing compressing writer: %w", err)
+		}
+	}
+	if f, ok := w.ResponseWriter.(finisher); ok {
+		return f.finish()
+	}
+	return nil
+}
+
+// newEncoder returns a streaming compressor for coding ("gzip" or
+// "deflate") that writes its compressed output to w.
+func newEncoder(coding string, w io.Writer) (io.WriteCloser, error) {
+	switch coding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "deflate":
+		return flate.NewWriter(w, flate.DefaultCompression)
+	default:
+		// selectEncoding only ever returns a name from supportedEncodings.
+		return nil, &UnsupportedEncodingError{Method: coding}
+	}
+}
+
+type UnsupportedEncodingError struct {
+	Method string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("tried to use unsupported encoding method: %s", e.Method)
+}
+
+// supportedEncodings lists the codings selectEncoding may choose, in order
+// of preference. "identity" is handled separately since it never compresses.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// incompressibleContentTypes are content types treated as already
+// compressed, and therefore not worth spending CPU trying to compress
+// again. *compressionMinSize applies alongside this: a body smaller than
+// that, of any content type, also isn't worth the compression overhead.
+var incompressibleContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"application/zip":          true,
+	"application/gzip":         true,
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"video/mp4":                true,
+}
+
+// selectEncoding parses an Accept-Encoding header per RFC 7231 §5.3.4 and
+// returns whichever supportedEncodings coding the client weighted highest
+// by q-value, breaking ties by supportedEncodings' own order. Returns "" if
+// the client didn't ask for one (including when the header is absent), asked
+// only for codings we don't support, or explicitly weighted "identity" above
+// every supported coding. A wildcard does not stand in for "identity".
+func selectEncoding(acceptEncodingHeader string) string {
+	prefs := parseAcceptEncoding(acceptEncodingHeader)
+	if len(prefs) == 0 {
+		return ""
+	}
+
+	wildcardQ, hasWildcard := prefs["*"]
+
+	best := ""
+	bestQ := 0.0
+	for _, coding := range supportedEncodings {
+		q, explicit := prefs[coding]
+		switch {
+		case explicit:
+			// Any q, including 0 ("coding;q=0", explicitly forbidden), falls through below.
+		case hasWildcard:
+			q = wildcardQ
+		default:
+			continue // Not mentioned at all, and no wildcard to fall back on.
+		}
+
+		if q > 0 && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	if identityQ, ok := prefs["identity"]; ok && identityQ > bestQ {
+		return ""
+	}
+	return best // "" falls back to identity; forcing a 406 for this is more than this server needs to do
+}
+
+// parseAcceptEncoding splits an Accept-Encoding header into a map of coding
+// name (lowercased) to its q-value, defaulting unspecified q-values to 1.
+func parseAcceptEncoding(header string) map[string]float64 {
+	prefs := make(map[string]float64)
+	if strings.TrimSpace(header) == "" {
+		return prefs
+	}
+
+	for _, token := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(token, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		if qValue, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(qValue), 64); err == nil {
+				q = parsed
+			}
+		}
+		prefs[name] = q
+	}
+
+	return prefs
+}